@@ -0,0 +1,233 @@
+package libp2pwebrtcprivate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/p2p/transport/webrtcprivate/pb"
+	"github.com/libp2p/go-msgio/pbio"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// defaultCandidateBatchWindow is how long writeBatchedCandidates waits
+	// after the first buffered candidate before flushing, coalescing any
+	// candidates Pion delivers in quick succession into one pb.Message
+	// instead of writing one message per candidate on the relayed stream.
+	defaultCandidateBatchWindow = 20 * time.Millisecond
+	// postGatheringGraceTimeout is the default grace period setupConnection
+	// allows, after both sides have signaled that candidate gathering is
+	// complete, for the ICE pair check to succeed *and* for the DTLS and
+	// SCTP handshakes that follow it. Gathering itself usually finishes in
+	// well under a second with STUN, so this is effectively the real
+	// connect-time budget on most dials; it needs to stay large enough for
+	// the slowest legs (TURN-relayed or lossy symmetric-NAT paths) rather
+	// than just the median one.
+	postGatheringGraceTimeout = 20 * time.Second
+	// candidateBatchByteLimit bounds the total encoded candidate length
+	// writeBatchedCandidates will accumulate before flushing early, leaving
+	// headroom under maxMsgSize for the surrounding pb.Message framing and
+	// field overhead. Without this, a burst of host/srflx/TURN-relay
+	// candidates arriving within a single batch window (more likely now that
+	// chunk0-3 adds TURN servers, each contributing its own relay
+	// candidate) could produce a message pbio.NewDelimitedReader on the
+	// other end refuses to read.
+	candidateBatchByteLimit = maxMsgSize - 256
+)
+
+// writeBatchedCandidates coalesces locally gathered ICE candidates arriving
+// on candidates within window into a single batched pb.Message. Once end
+// fires (Pion delivered a nil candidate, meaning local gathering finished)
+// any pending batch is flushed and an ICE_CANDIDATES_END sentinel is
+// written so the remote side knows no more candidates are coming.
+//
+// Multi-candidate batches and the ICE_CANDIDATES_END sentinel are only
+// understood by a peer running this batching-aware reader; both ends of a
+// connection need to be upgraded together. A single-candidate batch still
+// sets the legacy Data field so it round-trips with a pre-batching reader.
+func writeBatchedCandidates(ctx context.Context, w pbio.Writer, candidates <-chan string, end <-chan struct{}, window time.Duration) error {
+	if window <= 0 {
+		window = defaultCandidateBatchWindow
+	}
+	var batch []string
+	var batchSize int
+	var timer *time.Timer
+	var timerCh <-chan time.Time
+
+	stopTimer := func() {
+		if timer != nil {
+			timer.Stop()
+			timer = nil
+			timerCh = nil
+		}
+	}
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		msg := pb.Message{Type: pb.Message_ICE_CANDIDATE.Enum(), Candidates: batch}
+		if len(batch) == 1 {
+			msg.Data = &batch[0]
+		}
+		batch = nil
+		batchSize = 0
+		return w.WriteMsg(&msg)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			stopTimer()
+			return ctx.Err()
+		case c := <-candidates:
+			if len(batch) > 0 && batchSize+len(c) > candidateBatchByteLimit {
+				stopTimer()
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			batch = append(batch, c)
+			batchSize += len(c)
+			if timer == nil {
+				timer = time.NewTimer(window)
+				timerCh = timer.C
+			}
+		case <-timerCh:
+			timer = nil
+			timerCh = nil
+			if err := flush(); err != nil {
+				return err
+			}
+		case <-end:
+			stopTimer()
+			// Candidates still queued behind the end signal go out first.
+			for len(candidates) > 0 {
+				c := <-candidates
+				if len(batch) > 0 && batchSize+len(c) > candidateBatchByteLimit {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+				batch = append(batch, c)
+				batchSize += len(c)
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+			endMsg := pb.Message{Type: pb.Message_ICE_CANDIDATES_END.Enum()}
+			return w.WriteMsg(&endMsg)
+		}
+	}
+}
+
+// icePeerConnection is the subset of *webrtc.PeerConnection that
+// readCandidates needs, so tests can exercise it against a fake.
+type icePeerConnection interface {
+	AddICECandidate(webrtc.ICECandidateInit) error
+}
+
+// readCandidates reads ICE_CANDIDATE and ICE_CANDIDATES_END messages from r
+// until ctx is done, EOF, or a protocol error, applying each candidate to
+// pc and notifying gathering when the remote signals it has finished
+// gathering. It accepts both the legacy single-candidate form (Data) and
+// the batched form (Candidates) for backward compatibility. onEmpty, if
+// non-nil, is called with a log message when a message carries no
+// candidates at all (Pion's OnCandidate callback can fire with a nil
+// candidate, which the legacy writer encoded as an empty message).
+func readCandidates(ctx context.Context, r pbio.Reader, pc icePeerConnection, gathering *gatheringTracker, onEmpty func(string)) error {
+	var msg pb.Message
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := r.ReadMsg(&msg)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read failed: %w", err)
+		}
+		if msg.Type != nil && *msg.Type == pb.Message_ICE_CANDIDATES_END {
+			// The remote has finished gathering; once we have too, the
+			// only thing left is the ICE pair check, so we can afford to
+			// stop waiting sooner than connectTimeout.
+			gathering.RemoteDone()
+			continue
+		}
+		if msg.Type == nil || *msg.Type != pb.Message_ICE_CANDIDATE {
+			return fmt.Errorf("invalid message: expected %s got %s", pb.Message_ICE_CANDIDATE, msg.Type)
+		}
+
+		// Accept both the legacy single-candidate form (Data) and the
+		// batched form (Candidates) for backward compatibility with peers
+		// that haven't been upgraded yet.
+		msgCandidates := msg.Candidates
+		if len(msgCandidates) == 0 && msg.Data != nil && *msg.Data != "" {
+			msgCandidates = []string{*msg.Data}
+		}
+		if len(msgCandidates) == 0 {
+			// Ignore without erroring on empty message.
+			// Pion has a case where OnCandidate callback may be called
+			// with a nil candidate.
+			if onEmpty != nil {
+				onEmpty("received empty candidate message")
+			}
+			continue
+		}
+
+		for _, data := range msgCandidates {
+			var init webrtc.ICECandidateInit
+			if err := json.Unmarshal([]byte(data), &init); err != nil {
+				return fmt.Errorf("failed to unmarshal ice candidate %w", err)
+			}
+			if err := pc.AddICECandidate(init); err != nil {
+				return fmt.Errorf("failed to add ice candidate: %w", err)
+			}
+		}
+	}
+}
+
+// gatheringTracker calls shorten once both the local and the remote side
+// have finished ICE candidate gathering, so setupConnection can lower its
+// remaining wait toward a floor instead of running all the way to
+// connectTimeout once only the ICE pair check is left.
+type gatheringTracker struct {
+	shorten func()
+
+	mu         sync.Mutex
+	localDone  bool
+	remoteDone bool
+	fired      bool
+}
+
+func newGatheringTracker(shorten func()) *gatheringTracker {
+	return &gatheringTracker{shorten: shorten}
+}
+
+func (g *gatheringTracker) LocalDone()  { g.markDone(true, false) }
+func (g *gatheringTracker) RemoteDone() { g.markDone(false, true) }
+
+func (g *gatheringTracker) markDone(local, remote bool) {
+	g.mu.Lock()
+	if local {
+		g.localDone = true
+	}
+	if remote {
+		g.remoteDone = true
+	}
+	fire := g.localDone && g.remoteDone && !g.fired
+	if fire {
+		g.fired = true
+	}
+	g.mu.Unlock()
+
+	if fire {
+		g.shorten()
+	}
+}
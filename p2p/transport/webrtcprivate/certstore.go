@@ -0,0 +1,181 @@
+package libp2pwebrtcprivate
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// defaultCertificateValidity is how long a generated webrtc certificate
+	// is valid; it's rotated certificateRotationOverlap before that ends.
+	defaultCertificateValidity = 30 * 24 * time.Hour
+	// certificateRotationOverlap is how far ahead of expiry a replacement
+	// certificate is generated (and persisted) so that connections already
+	// negotiated with the outgoing certificate aren't disrupted by an
+	// abrupt swap.
+	certificateRotationOverlap = 24 * time.Hour
+
+	certKeyFileName  = "webrtc_cert.key.pem"
+	certCertFileName = "webrtc_cert.crt.pem"
+)
+
+// StoredCertificate pairs a webrtc.Certificate with the PEM-encoded private
+// key and certificate bytes a CertificateStore needs to persist it, since
+// webrtc.Certificate itself doesn't expose its underlying key or DER bytes.
+type StoredCertificate struct {
+	Certificate *webrtc.Certificate
+	KeyPEM      []byte
+	CertPEM     []byte
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the certificate, formatted
+// as colon-separated uppercase hex pairs the same way SDP fingerprints are.
+func (c *StoredCertificate) Fingerprint() (string, error) {
+	block, _ := pem.Decode(c.CertPEM)
+	if block == nil {
+		return "", errors.New("corrupted certificate PEM")
+	}
+	sum := sha256.Sum256(block.Bytes)
+	fp := make([]byte, 0, len(sum)*3-1)
+	for i, b := range sum {
+		if i > 0 {
+			fp = append(fp, ':')
+		}
+		fp = append(fp, fmt.Sprintf("%02X", b)...)
+	}
+	return string(fp), nil
+}
+
+// CertificateStore loads and persists the WebRTC DTLS certificate used to
+// identify this host across restarts. Without one, a transport generates a
+// fresh certificate every time the process starts, which changes the DTLS
+// fingerprint, and therefore the effective identity presented in SDP, on
+// every restart.
+type CertificateStore interface {
+	Load(ctx context.Context) (*StoredCertificate, error)
+	Store(ctx context.Context, cert *StoredCertificate) error
+}
+
+// FSCertificateStore is a CertificateStore backed by PEM files on disk,
+// rooted at Dir (typically the host's datastore directory).
+type FSCertificateStore struct {
+	Dir string
+}
+
+// NewFSCertificateStore returns a CertificateStore that persists the
+// certificate as PEM files under dir. dir isn't created until the first
+// Store call, which creates it with 0700 permissions if it doesn't already
+// exist.
+func NewFSCertificateStore(dir string) *FSCertificateStore {
+	return &FSCertificateStore{Dir: dir}
+}
+
+// Load reads the persisted key and certificate from disk. It returns a nil
+// StoredCertificate (no error) if nothing has been persisted yet, and an
+// error if the files exist but are corrupted, so callers can fall back to
+// generating a fresh certificate rather than failing to start.
+func (s *FSCertificateStore) Load(ctx context.Context) (*StoredCertificate, error) {
+	keyPEM, err := os.ReadFile(filepath.Join(s.Dir, certKeyFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", certKeyFileName, err)
+	}
+	certPEM, err := os.ReadFile(filepath.Join(s.Dir, certCertFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", certCertFileName, err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("corrupted %s: not a valid PEM file", certKeyFileName)
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("corrupted %s: %w", certKeyFileName, err)
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("corrupted %s: not a valid PEM file", certCertFileName)
+	}
+	x509Cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("corrupted %s: %w", certCertFileName, err)
+	}
+
+	cert := webrtc.CertificateFromX509(key, x509Cert)
+	return &StoredCertificate{Certificate: &cert, KeyPEM: keyPEM, CertPEM: certPEM}, nil
+}
+
+// Store writes cert's key and certificate PEM to disk, creating Dir if
+// necessary.
+func (s *FSCertificateStore) Store(ctx context.Context, cert *StoredCertificate) error {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", s.Dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(s.Dir, certKeyFileName), cert.KeyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", certKeyFileName, err)
+	}
+	if err := os.WriteFile(filepath.Join(s.Dir, certCertFileName), cert.CertPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", certCertFileName, err)
+	}
+	return nil
+}
+
+// generateCertificate creates a new self-signed ECDSA P-256 certificate
+// valid for validity, the same key type newTransport always used, since
+// Chromium only supports ECDSA P-256 or RSA key signatures in the webrtc TLS
+// certificate (P-228 and P-384 fail the DTLS handshake with Illegal
+// Parameter).
+func generateCertificate(validity time.Duration) (*StoredCertificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key for cert: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return nil, fmt.Errorf("generate cert serial: %w", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "libp2p webrtc"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("create x509 certificate: %w", err)
+	}
+	x509Cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("parse generated certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal cert key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	cert := webrtc.CertificateFromX509(key, x509Cert)
+	return &StoredCertificate{Certificate: &cert, KeyPEM: keyPEM, CertPEM: certPEM}, nil
+}
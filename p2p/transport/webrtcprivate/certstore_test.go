@@ -0,0 +1,122 @@
+package libp2pwebrtcprivate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSCertificateStore_LoadMissingReturnsNilNil(t *testing.T) {
+	store := NewFSCertificateStore(t.TempDir())
+	stored, err := store.Load(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, stored)
+}
+
+func TestFSCertificateStore_StoreLoadRoundTrip(t *testing.T) {
+	store := NewFSCertificateStore(filepath.Join(t.TempDir(), "certs"))
+	ctx := context.Background()
+
+	want, err := generateCertificate(time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, store.Store(ctx, want))
+
+	got, err := store.Load(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+
+	wantFP, err := want.Fingerprint()
+	require.NoError(t, err)
+	gotFP, err := got.Fingerprint()
+	require.NoError(t, err)
+	require.Equal(t, wantFP, gotFP)
+}
+
+func TestFSCertificateStore_CorruptedKeyFileRecovery(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFSCertificateStore(dir)
+	ctx := context.Background()
+
+	cert, err := generateCertificate(time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, store.Store(ctx, cert))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, certKeyFileName), []byte("not a pem file"), 0600))
+
+	_, err = store.Load(ctx)
+	require.Error(t, err)
+}
+
+func TestFSCertificateStore_CorruptedCertFileRecovery(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFSCertificateStore(dir)
+	ctx := context.Background()
+
+	cert, err := generateCertificate(time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, store.Store(ctx, cert))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, certCertFileName), []byte("not a pem file"), 0600))
+
+	_, err = store.Load(ctx)
+	require.Error(t, err)
+}
+
+func TestTransport_CertificateRotatesWhenWithinOverlapOfExpiry(t *testing.T) {
+	tr := &transport{
+		certStore: NewFSCertificateStore(t.TempDir()),
+		// Shorter than certificateRotationOverlap, so
+		// loadOrGenerateCertificate always finds the stored cert too close
+		// to expiry and regenerates.
+		certValidity: time.Hour,
+	}
+
+	ctx := context.Background()
+	first, err := tr.certificate(ctx)
+	require.NoError(t, err)
+	firstFP, err := tr.CertificateFingerprint()
+	require.NoError(t, err)
+
+	tr.certMu.Lock()
+	tr.currentCert = nil // force certificate() to re-evaluate via the store
+	tr.certMu.Unlock()
+
+	second, err := tr.certificate(ctx)
+	require.NoError(t, err)
+	secondFP, err := tr.CertificateFingerprint()
+	require.NoError(t, err)
+
+	require.NotEqual(t, firstFP, secondFP)
+	require.NotEqual(t, first, second)
+}
+
+func TestTransport_CertificateLoadedFromStoreWhenFarFromExpiry(t *testing.T) {
+	dir := t.TempDir()
+
+	tr1 := &transport{
+		certStore:    NewFSCertificateStore(dir),
+		certValidity: 60 * 24 * time.Hour, // well beyond certificateRotationOverlap
+	}
+
+	ctx := context.Background()
+	_, err := tr1.certificate(ctx)
+	require.NoError(t, err)
+	fp1, err := tr1.CertificateFingerprint()
+	require.NoError(t, err)
+
+	tr2 := &transport{
+		certStore:    NewFSCertificateStore(dir),
+		certValidity: 60 * 24 * time.Hour,
+	}
+
+	_, err = tr2.certificate(ctx)
+	require.NoError(t, err)
+	fp2, err := tr2.CertificateFingerprint()
+	require.NoError(t, err)
+
+	require.Equal(t, fp1, fp2, "a fresh transport instance should load the persisted certificate rather than generating a new one")
+}
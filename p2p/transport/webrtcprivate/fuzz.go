@@ -0,0 +1,148 @@
+package libp2pwebrtcprivate
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/libp2p/go-msgio/pbio"
+)
+
+// FuzzMode selects how a fuzzWriter perturbs outgoing signaling messages.
+type FuzzMode int
+
+const (
+	// FuzzDrop silently discards an outgoing message with probability Prob.
+	FuzzDrop FuzzMode = iota
+	// FuzzDelay sleeps for a uniform random duration in [0, MaxDelay] before
+	// writing an outgoing message.
+	FuzzDelay
+	// FuzzReorder buffers up to ReorderWindow outgoing messages and flushes
+	// them in shuffled order.
+	FuzzReorder
+	// FuzzDuplicate re-sends the previously written message with probability
+	// Prob before writing the current one.
+	FuzzDuplicate
+)
+
+// FuzzConfig configures the optional fault-injection wrapper around the
+// /webrtc-signaling writer used by setupConnection (the dialing side only).
+// It exists so tests can deterministically assert that connectTimeout, the
+// readErr/writeErr channels and the ICE gatherer all recover cleanly from a
+// lossy or misordered relay link: the same class of candidate-loss races
+// that's otherwise only reproducible against a real symmetric NAT.
+type FuzzConfig struct {
+	// Mode selects the perturbation applied to outgoing writes.
+	Mode FuzzMode
+	// Prob is the probability, in [0, 1], that FuzzDrop or FuzzDuplicate
+	// fires on a given write. Ignored by FuzzDelay and FuzzReorder.
+	Prob float64
+	// MaxDelay bounds the uniform random sleep injected by FuzzDelay.
+	MaxDelay time.Duration
+	// ReorderWindow is the number of writes fuzzWriter buffers before
+	// flushing them in shuffled order when Mode is FuzzReorder. Defaults to
+	// 4 when unset.
+	ReorderWindow int
+	// Seed makes the injected faults reproducible across runs.
+	Seed int64
+}
+
+// fuzzWriter wraps a pbio.Writer according to cfg so tests can inject
+// deterministic signaling faults without a real lossy network. It perturbs
+// whole WriteMsg calls rather than raw bytes, so a drop/duplicate/reorder
+// always acts on an entire message: pbio's delimited framing writes a
+// message as a length-prefix write followed by a payload write, and
+// perturbing those independently would desynchronize the framing instead of
+// cleanly dropping/reordering a message.
+type fuzzWriter struct {
+	w   pbio.Writer
+	cfg FuzzConfig
+	rng *rand.Rand
+
+	mu   sync.Mutex
+	last proto.Message
+	buf  []proto.Message
+}
+
+// newFuzzWriter wraps w so every WriteMsg issued against the returned writer
+// is perturbed according to cfg.
+func newFuzzWriter(w pbio.Writer, cfg FuzzConfig) *fuzzWriter {
+	if cfg.ReorderWindow <= 0 {
+		cfg.ReorderWindow = 4
+	}
+	return &fuzzWriter{
+		w:   w,
+		cfg: cfg,
+		rng: rand.New(rand.NewSource(cfg.Seed)),
+	}
+}
+
+func (f *fuzzWriter) WriteMsg(msg proto.Message) error {
+	f.mu.Lock()
+	switch f.cfg.Mode {
+	case FuzzDrop:
+		drop := f.rng.Float64() < f.cfg.Prob
+		f.mu.Unlock()
+		if drop {
+			return nil
+		}
+		return f.w.WriteMsg(msg)
+	case FuzzDelay:
+		d := time.Duration(f.rng.Int63n(int64(f.cfg.MaxDelay) + 1))
+		f.mu.Unlock()
+		time.Sleep(d)
+		return f.w.WriteMsg(msg)
+	case FuzzDuplicate:
+		dup := f.rng.Float64() < f.cfg.Prob
+		last := f.last
+		f.last = msg
+		f.mu.Unlock()
+		if dup && last != nil {
+			if err := f.w.WriteMsg(last); err != nil {
+				return err
+			}
+		}
+		return f.w.WriteMsg(msg)
+	case FuzzReorder:
+		f.buf = append(f.buf, msg)
+		if len(f.buf) < f.cfg.ReorderWindow {
+			f.mu.Unlock()
+			return nil
+		}
+		batch := f.buf
+		f.buf = nil
+		f.rng.Shuffle(len(batch), func(i, j int) { batch[i], batch[j] = batch[j], batch[i] })
+		f.mu.Unlock()
+		for _, m := range batch {
+			if err := f.w.WriteMsg(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		f.mu.Unlock()
+		return f.w.WriteMsg(msg)
+	}
+}
+
+// Flush writes out any message still buffered by FuzzReorder, e.g. because
+// the stream is closing before ReorderWindow messages accumulated. It's a
+// no-op for the other modes.
+func (f *fuzzWriter) Flush() error {
+	f.mu.Lock()
+	if f.cfg.Mode != FuzzReorder || len(f.buf) == 0 {
+		f.mu.Unlock()
+		return nil
+	}
+	batch := f.buf
+	f.buf = nil
+	f.rng.Shuffle(len(batch), func(i, j int) { batch[i], batch[j] = batch[j], batch[i] })
+	f.mu.Unlock()
+	for _, m := range batch {
+		if err := f.w.WriteMsg(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package libp2pwebrtcprivate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/libp2p/go-libp2p/p2p/transport/webrtcprivate/pb"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMsgWriter records every message it's asked to write, in order, so
+// tests can assert on what a fuzzWriter actually forwarded.
+type fakeMsgWriter struct {
+	msgs []*pb.Message
+}
+
+func (f *fakeMsgWriter) WriteMsg(msg proto.Message) error {
+	f.msgs = append(f.msgs, msg.(*pb.Message))
+	return nil
+}
+
+func (f *fakeMsgWriter) data() []string {
+	out := make([]string, len(f.msgs))
+	for i, m := range f.msgs {
+		out[i] = m.GetData()
+	}
+	return out
+}
+
+func candidateMsg(data string) *pb.Message {
+	return &pb.Message{Type: pb.Message_ICE_CANDIDATE.Enum(), Data: &data}
+}
+
+func TestFuzzWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     FuzzConfig
+		in      []string
+		wantLen int
+	}{
+		{
+			name:    "drop always drops every message",
+			cfg:     FuzzConfig{Mode: FuzzDrop, Prob: 1},
+			in:      []string{"a", "b", "c"},
+			wantLen: 0,
+		},
+		{
+			name:    "drop never drops when prob is zero",
+			cfg:     FuzzConfig{Mode: FuzzDrop, Prob: 0},
+			in:      []string{"a", "b", "c"},
+			wantLen: 3,
+		},
+		{
+			name:    "duplicate always re-sends the previous message",
+			cfg:     FuzzConfig{Mode: FuzzDuplicate, Prob: 1},
+			in:      []string{"a", "b", "c"},
+			wantLen: 5, // a (no previous to dup), dup(a), b, dup(b), c
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fw := &fakeMsgWriter{}
+			w := newFuzzWriter(fw, tc.cfg)
+			for _, data := range tc.in {
+				require.NoError(t, w.WriteMsg(candidateMsg(data)))
+			}
+			require.Len(t, fw.msgs, tc.wantLen)
+		})
+	}
+}
+
+func TestFuzzWriter_DuplicateOrder(t *testing.T) {
+	fw := &fakeMsgWriter{}
+	w := newFuzzWriter(fw, FuzzConfig{Mode: FuzzDuplicate, Prob: 1})
+
+	require.NoError(t, w.WriteMsg(candidateMsg("a")))
+	require.NoError(t, w.WriteMsg(candidateMsg("b")))
+
+	require.Equal(t, []string{"a", "a", "b"}, fw.data())
+}
+
+func TestFuzzWriter_DelaySleepsWithinMaxDelay(t *testing.T) {
+	fw := &fakeMsgWriter{}
+	const maxDelay = 50 * time.Millisecond
+	w := newFuzzWriter(fw, FuzzConfig{Mode: FuzzDelay, MaxDelay: maxDelay, Seed: 1})
+
+	start := time.Now()
+	require.NoError(t, w.WriteMsg(candidateMsg("a")))
+	elapsed := time.Since(start)
+
+	require.Len(t, fw.msgs, 1)
+	require.GreaterOrEqual(t, elapsed, time.Duration(0))
+	require.LessOrEqual(t, elapsed, maxDelay+100*time.Millisecond, "delay should be bounded by MaxDelay, plus slack for scheduling")
+}
+
+func TestFuzzWriter_ReorderBuffersWholeMessagesThenFlushes(t *testing.T) {
+	fw := &fakeMsgWriter{}
+	w := newFuzzWriter(fw, FuzzConfig{Mode: FuzzReorder, ReorderWindow: 3, Seed: 1})
+
+	require.NoError(t, w.WriteMsg(candidateMsg("a")))
+	require.NoError(t, w.WriteMsg(candidateMsg("b")))
+	require.Empty(t, fw.msgs, "writer should still be buffering below the window")
+
+	require.NoError(t, w.WriteMsg(candidateMsg("c")))
+	require.Len(t, fw.msgs, 3, "a full window should flush atomically")
+	require.ElementsMatch(t, []string{"a", "b", "c"}, fw.data())
+}
+
+func TestFuzzWriter_FlushWritesPartialReorderBuffer(t *testing.T) {
+	fw := &fakeMsgWriter{}
+	w := newFuzzWriter(fw, FuzzConfig{Mode: FuzzReorder, ReorderWindow: 4, Seed: 1})
+
+	require.NoError(t, w.WriteMsg(candidateMsg("a")))
+	require.NoError(t, w.WriteMsg(candidateMsg("b")))
+	require.Empty(t, fw.msgs)
+
+	require.NoError(t, w.Flush())
+	require.ElementsMatch(t, []string{"a", "b"}, fw.data())
+
+	// A second Flush with nothing buffered is a no-op.
+	require.NoError(t, w.Flush())
+	require.Len(t, fw.msgs, 2)
+}
+
+func TestFuzzWriter_PassThroughWhenNoFault(t *testing.T) {
+	fw := &fakeMsgWriter{}
+	w := newFuzzWriter(fw, FuzzConfig{Mode: FuzzDrop, Prob: 0})
+
+	require.NoError(t, w.WriteMsg(candidateMsg("a")))
+	require.Equal(t, []string{"a"}, fw.data())
+}
@@ -0,0 +1,91 @@
+package libp2pwebrtcprivate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// iceCredentialRefreshMargin is how far ahead of an ICEServerProvider's
+// reported expiry the transport proactively fetches new credentials, so an
+// in-flight NewPeerConnection call never races a TURN credential going
+// stale mid-dial.
+const iceCredentialRefreshMargin = time.Minute
+
+// ICEServerProvider supplies the ICE servers (STUN and/or TURN) used when
+// building a new webrtc.PeerConnection, along with the time at which those
+// credentials expire. A zero expiry means the servers never expire.
+type ICEServerProvider interface {
+	ICEServers(ctx context.Context) ([]webrtc.ICEServer, time.Time, error)
+}
+
+// staticICEServerProvider is the default ICEServerProvider: it always
+// returns the fixed server list AddTransport was called with and never
+// expires.
+type staticICEServerProvider struct {
+	servers []webrtc.ICEServer
+}
+
+func (p *staticICEServerProvider) ICEServers(context.Context) ([]webrtc.ICEServer, time.Time, error) {
+	return p.servers, time.Time{}, nil
+}
+
+// HTTPICEServerProvider fetches ICE servers, including short-lived TURN
+// credentials, from an HTTP endpoint such as a coturn REST API and honors
+// the TTL the endpoint returns. This unlocks TURN relay fallback for peers
+// behind symmetric NAT, where plain STUN fails silently.
+type HTTPICEServerProvider struct {
+	// Endpoint is the URL queried for ICE server credentials.
+	Endpoint string
+	// Client is used to make the request. http.DefaultClient is used if nil.
+	Client *http.Client
+}
+
+// iceServerCredentialResponse is the expected shape of the coturn-style REST
+// response: a TTL in seconds alongside the usual iceServers list.
+type iceServerCredentialResponse struct {
+	TTL        int `json:"ttl"`
+	ICEServers []struct {
+		URLs       []string `json:"urls"`
+		Username   string   `json:"username"`
+		Credential string   `json:"credential"`
+	} `json:"iceServers"`
+}
+
+func (p *HTTPICEServerProvider) ICEServers(ctx context.Context) ([]webrtc.ICEServer, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Endpoint, nil)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to build ICE credential request: %w", err)
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to fetch ICE credentials from %s: %w", p.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("ICE credential endpoint %s returned %s", p.Endpoint, resp.Status)
+	}
+
+	var body iceServerCredentialResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to decode ICE credentials from %s: %w", p.Endpoint, err)
+	}
+
+	servers := make([]webrtc.ICEServer, 0, len(body.ICEServers))
+	for _, s := range body.ICEServers {
+		servers = append(servers, webrtc.ICEServer{
+			URLs:       s.URLs,
+			Username:   s.Username,
+			Credential: s.Credential,
+		})
+	}
+	return servers, time.Now().Add(time.Duration(body.TTL) * time.Second), nil
+}
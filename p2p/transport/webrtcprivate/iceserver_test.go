@@ -0,0 +1,118 @@
+package libp2pwebrtcprivate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPICEServerProvider_ParsesServersAndTTL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ttl":300,"iceServers":[{"urls":["turn:turn.example:3478"],"username":"u","credential":"c"}]}`))
+	}))
+	defer srv.Close()
+
+	p := &HTTPICEServerProvider{Endpoint: srv.URL}
+	before := time.Now()
+	servers, expiry, err := p.ICEServers(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []webrtc.ICEServer{{
+		URLs:       []string{"turn:turn.example:3478"},
+		Username:   "u",
+		Credential: "c",
+	}}, servers)
+	require.WithinDuration(t, before.Add(300*time.Second), expiry, 2*time.Second)
+}
+
+func TestHTTPICEServerProvider_NonOKStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	p := &HTTPICEServerProvider{Endpoint: srv.URL}
+	_, _, err := p.ICEServers(context.Background())
+	require.Error(t, err)
+}
+
+func TestHTTPICEServerProvider_MalformedJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not json"))
+	}))
+	defer srv.Close()
+
+	p := &HTTPICEServerProvider{Endpoint: srv.URL}
+	_, _, err := p.ICEServers(context.Background())
+	require.Error(t, err)
+}
+
+// fakeICEServerProvider records how many times it was called and returns
+// whatever servers/expiry/err it was configured with, so tests can drive
+// transport.iceServers' refresh/fallback logic deterministically.
+type fakeICEServerProvider struct {
+	calls   int
+	servers []webrtc.ICEServer
+	expiry  time.Time
+	err     error
+}
+
+func (f *fakeICEServerProvider) ICEServers(context.Context) ([]webrtc.ICEServer, time.Time, error) {
+	f.calls++
+	return f.servers, f.expiry, f.err
+}
+
+func TestTransport_ICEServers_RefreshesWhenNearExpiry(t *testing.T) {
+	first := []webrtc.ICEServer{{URLs: []string{"stun:first"}}}
+	second := []webrtc.ICEServer{{URLs: []string{"stun:second"}}}
+	provider := &fakeICEServerProvider{servers: first, expiry: time.Now().Add(iceCredentialRefreshMargin / 2)}
+	tr := &transport{iceServerProvider: provider}
+
+	got, err := tr.iceServers(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, first, got)
+	require.Equal(t, 1, provider.calls)
+
+	// Cached credentials are already within the refresh margin of expiry, so
+	// the next call should refresh rather than reuse them.
+	provider.servers = second
+	provider.expiry = time.Now().Add(time.Hour)
+	got, err = tr.iceServers(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, second, got)
+	require.Equal(t, 2, provider.calls)
+}
+
+func TestTransport_ICEServers_StaticProviderNeverRefreshes(t *testing.T) {
+	servers := []webrtc.ICEServer{{URLs: []string{"stun:static"}}}
+	provider := &fakeICEServerProvider{servers: servers} // zero expiry: never expires
+	tr := &transport{iceServerProvider: provider}
+
+	for i := 0; i < 3; i++ {
+		got, err := tr.iceServers(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, servers, got)
+	}
+	require.Equal(t, 1, provider.calls, "a zero expiry should be treated as never needing a refresh")
+}
+
+func TestTransport_ICEServers_RefreshFailureFallsBackToCached(t *testing.T) {
+	cached := []webrtc.ICEServer{{URLs: []string{"stun:cached"}}}
+	provider := &fakeICEServerProvider{servers: cached, expiry: time.Now().Add(iceCredentialRefreshMargin / 2)}
+	tr := &transport{iceServerProvider: provider}
+
+	got, err := tr.iceServers(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, cached, got)
+
+	provider.err = errors.New("turn endpoint unreachable")
+	got, err = tr.iceServers(context.Background())
+	require.NoError(t, err, "a refresh failure should fall back to the cached servers rather than failing the dial")
+	require.Equal(t, cached, got)
+}
@@ -0,0 +1,92 @@
+// Generated from message.proto, mirroring protoc-gen-gogo's output shape.
+// The protoc/gogo toolchain isn't available in this checkout to regenerate
+// this file from message.proto directly; keep the two in sync by hand
+// until it is, then replace this with real generated output.
+
+package pb
+
+import (
+	proto "github.com/gogo/protobuf/proto"
+)
+
+type Message_Type int32
+
+const (
+	Message_SDP_OFFER          Message_Type = 0
+	Message_SDP_ANSWER         Message_Type = 1
+	Message_ICE_CANDIDATE      Message_Type = 2
+	Message_ICE_CANDIDATES_END Message_Type = 3
+)
+
+var Message_Type_name = map[int32]string{
+	0: "SDP_OFFER",
+	1: "SDP_ANSWER",
+	2: "ICE_CANDIDATE",
+	3: "ICE_CANDIDATES_END",
+}
+
+var Message_Type_value = map[string]int32{
+	"SDP_OFFER":          0,
+	"SDP_ANSWER":         1,
+	"ICE_CANDIDATE":      2,
+	"ICE_CANDIDATES_END": 3,
+}
+
+func (x Message_Type) Enum() *Message_Type {
+	p := new(Message_Type)
+	*p = x
+	return p
+}
+
+func (x Message_Type) String() string {
+	return proto.EnumName(Message_Type_name, int32(x))
+}
+
+func (x *Message_Type) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(Message_Type_value, data, "Message_Type")
+	if err != nil {
+		return err
+	}
+	*x = Message_Type(value)
+	return nil
+}
+
+type Message struct {
+	Type       *Message_Type `protobuf:"varint,1,opt,name=type,enum=webrtcprivate.pb.Message_Type" json:"type,omitempty"`
+	Data       *string       `protobuf:"bytes,2,opt,name=data" json:"data,omitempty"`
+	Candidates []string      `protobuf:"bytes,3,rep,name=candidates" json:"candidates,omitempty"`
+
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Message) Reset()         { *m = Message{} }
+func (m *Message) String() string { return proto.CompactTextString(m) }
+func (*Message) ProtoMessage()    {}
+
+func (m *Message) GetType() Message_Type {
+	if m != nil && m.Type != nil {
+		return *m.Type
+	}
+	return Message_SDP_OFFER
+}
+
+func (m *Message) GetData() string {
+	if m != nil && m.Data != nil {
+		return *m.Data
+	}
+	return ""
+}
+
+func (m *Message) GetCandidates() []string {
+	if m != nil {
+		return m.Candidates
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("webrtcprivate.pb.Message_Type", Message_Type_name, Message_Type_value)
+	proto.RegisterType((*Message)(nil), "webrtcprivate.pb.Message")
+}
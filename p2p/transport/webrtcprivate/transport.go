@@ -2,13 +2,9 @@ package libp2pwebrtcprivate
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net"
 	"sync"
 	"time"
@@ -26,6 +22,7 @@ import (
 	"github.com/libp2p/go-msgio/pbio"
 	"github.com/pion/webrtc/v3"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/sync/errgroup"
 
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
@@ -51,17 +48,127 @@ var (
 type transport struct {
 	host                   host.Host
 	rcmgr                  network.ResourceManager
-	webrtcConfig           webrtc.Configuration
 	gater                  connmgr.ConnectionGater
 	maxInFlightConnections int
 
+	// signalingFuzz, when set, wraps the writer of every /webrtc-signaling
+	// stream this transport dials in a fuzzWriter so tests can inject
+	// deterministic drops/delays/reordering/duplication of SDP and ICE
+	// messages.
+	signalingFuzz *FuzzConfig
+
+	iceServerProvider ICEServerProvider
+
+	iceMu            sync.Mutex
+	cachedICEServers []webrtc.ICEServer
+	cachedICEExpiry  time.Time
+
+	certStore    CertificateStore
+	certValidity time.Duration
+
+	certMu      sync.Mutex
+	currentCert *StoredCertificate
+
+	// candidateBatchWindow is how long writeBatchedCandidates coalesces
+	// outgoing ICE candidates before flushing them as one pb.Message.
+	candidateBatchWindow time.Duration
+	// candidateBatchGrace bounds how long setupConnection keeps waiting for
+	// the ICE pair check once both sides have signaled the end of
+	// candidate gathering.
+	candidateBatchGrace time.Duration
+
 	mu       sync.Mutex
 	listener *listener
 }
 
+// Note on listener/handleSignalingStream: Listen and RemoveListener below
+// reference a *listener and its handleSignalingStream method as the
+// answerer side of the signaling protocol, but neither is present in this
+// checkout (no listener.go file exists here to build or test against).
+// setupConnection's ctx/errgroup threading (WithCandidateBatchGrace's grace
+// window included) and signalingFuzz's fault injection are both dialer-only
+// as a direct consequence: there is no answerer-side source in this
+// checkout to thread ctx through or wrap a writer around. Making them
+// answerer-aware is follow-up work once handleSignalingStream's real
+// implementation is available to change and test against; doing it blind
+// here would mean shipping an unreviewed, untested reconstruction instead
+// of a fix to the actual existing code.
+
 var _ tpt.Transport = &transport{}
 
-func AddTransport(h host.Host, gater connmgr.ConnectionGater, stunServers []webrtc.ICEServer) (*transport, error) {
+// Option configures a transport constructed by AddTransport.
+type Option func(*transport) error
+
+// WithSignalingFuzz wraps the dialer's /webrtc-signaling writer in a
+// fault-injecting fuzzWriter driven by cfg. It is intended for tests
+// exercising candidate-loss and reordering races against a real Pion ICE
+// agent, and should not be used in production.
+//
+// It only wraps the dialing side: wrapping the answerer's writer too, so
+// fault injection can be asserted from either direction, requires
+// handleSignalingStream's real implementation, which isn't present in this
+// checkout (see the note below the transport struct). This is a scope
+// limitation of this checkout, not a decision that answerer-side fault
+// injection is undesirable.
+func WithSignalingFuzz(cfg FuzzConfig) Option {
+	return func(t *transport) error {
+		t.signalingFuzz = &cfg
+		return nil
+	}
+}
+
+// WithICEServerProvider overrides the source of ICE servers used to build
+// each new webrtc.PeerConnection. By default AddTransport wraps the
+// stunServers argument in a provider that never expires; pass this option to
+// plug in TURN credential rotation, e.g. HTTPICEServerProvider.
+func WithICEServerProvider(p ICEServerProvider) Option {
+	return func(t *transport) error {
+		t.iceServerProvider = p
+		return nil
+	}
+}
+
+// WithCertificateStore makes the transport's WebRTC DTLS certificate
+// persistent across restarts by loading it from (and rotating it through)
+// store, instead of generating a fresh one every time the process starts.
+// Without this option the certificate, and therefore the DTLS fingerprint
+// presented in SDP, changes on every restart.
+func WithCertificateStore(store CertificateStore) Option {
+	return func(t *transport) error {
+		t.certStore = store
+		return nil
+	}
+}
+
+// WithCandidateBatchWindow overrides how long outgoing ICE candidates are
+// coalesced before being flushed as a single batched message. It defaults
+// to defaultCandidateBatchWindow.
+func WithCandidateBatchWindow(d time.Duration) Option {
+	return func(t *transport) error {
+		t.candidateBatchWindow = d
+		return nil
+	}
+}
+
+// WithCandidateBatchGrace overrides how far setupConnection will shorten its
+// remaining wait for the ICE pair check once both sides have signaled the
+// end of candidate gathering. It defaults to postGatheringGraceTimeout.
+//
+// This grace window has to cover not just the ICE pair check but the DTLS
+// and SCTP handshakes that follow it, so setting it too low will turn dials
+// that the full connectTimeout would have tolerated into premature
+// "context deadline exceeded" failures. That risk is concentrated on
+// TURN-relayed or lossy symmetric-NAT paths, where those handshakes are
+// slowest; don't lower this below a few seconds unless you've measured the
+// handshake latency on the paths you actually dial.
+func WithCandidateBatchGrace(d time.Duration) Option {
+	return func(t *transport) error {
+		t.candidateBatchGrace = d
+		return nil
+	}
+}
+
+func AddTransport(h host.Host, gater connmgr.ConnectionGater, stunServers []webrtc.ICEServer, opts ...Option) (*transport, error) {
 	n, ok := h.Network().(tpt.TransportNetwork)
 	if !ok {
 		return nil, fmt.Errorf("%v is not a transport network", h.Network())
@@ -72,6 +179,12 @@ func AddTransport(h host.Host, gater connmgr.ConnectionGater, stunServers []webr
 		return nil, err
 	}
 
+	for _, opt := range opts {
+		if err := opt(t); err != nil {
+			return nil, fmt.Errorf("failed to apply option: %w", err)
+		}
+	}
+
 	if err := n.AddTransport(t); err != nil {
 		return nil, fmt.Errorf("failed to add transport to network: %w", err)
 	}
@@ -84,39 +197,113 @@ func AddTransport(h host.Host, gater connmgr.ConnectionGater, stunServers []webr
 }
 
 func newTransport(h host.Host, gater connmgr.ConnectionGater, stunServers []webrtc.ICEServer) (*transport, error) {
-	// We use elliptic P-256 since it is widely supported by browsers.
-	//
-	// Implementation note: Testing with the browser,
-	// it seems like Chromium only supports ECDSA P-256 or RSA key signatures in the webrtc TLS certificate.
-	// We tried using P-228 and P-384 which caused the DTLS handshake to fail with Illegal Parameter
-	//
-	// Please refer to this is a list of suggested algorithms for the WebCrypto API.
-	// The algorithm for generating a certificate for an RTCPeerConnection
-	// must adhere to the WebCrpyto API. From my observation,
-	// RSA and ECDSA P-256 is supported on almost all browsers.
-	// Ed25519 is not present on the list.
-	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return nil, fmt.Errorf("generate key for cert: %w", err)
-	}
-	cert, err := webrtc.GenerateCertificate(pk)
-	if err != nil {
-		return nil, fmt.Errorf("generate certificate: %w", err)
-	}
-	config := webrtc.Configuration{
-		Certificates: []webrtc.Certificate{*cert},
-		ICEServers:   stunServers,
-	}
-
 	return &transport{
 		host:                   h,
 		rcmgr:                  h.Network().ResourceManager(),
-		webrtcConfig:           config,
 		maxInFlightConnections: 16,
 		gater:                  gater,
+		iceServerProvider:      &staticICEServerProvider{servers: stunServers},
+		certValidity:           defaultCertificateValidity,
+		candidateBatchWindow:   defaultCandidateBatchWindow,
+		candidateBatchGrace:    postGatheringGraceTimeout,
 	}, nil
 }
 
+// certificate returns the webrtc.Certificate to use for a new peer
+// connection, loading or generating one via certStore (if set) and rotating
+// it once it's within certificateRotationOverlap of expiry. Without a
+// certStore this generates an in-memory certificate on first use and
+// generates a new one on each such rotation; nothing is persisted.
+func (t *transport) certificate(ctx context.Context) (*webrtc.Certificate, error) {
+	t.certMu.Lock()
+	current := t.currentCert
+	needsRotation := current == nil || time.Until(current.Certificate.Expires()) < certificateRotationOverlap
+	t.certMu.Unlock()
+	if !needsRotation {
+		return current.Certificate, nil
+	}
+
+	stored, err := t.loadOrGenerateCertificate(ctx)
+	if err != nil {
+		if current != nil {
+			log.Warnw("failed to rotate webrtc certificate, reusing current one", "error", err)
+			return current.Certificate, nil
+		}
+		return nil, err
+	}
+
+	t.certMu.Lock()
+	t.currentCert = stored
+	t.certMu.Unlock()
+	return stored.Certificate, nil
+}
+
+func (t *transport) loadOrGenerateCertificate(ctx context.Context) (*StoredCertificate, error) {
+	if t.certStore != nil {
+		stored, err := t.certStore.Load(ctx)
+		if err != nil {
+			log.Warnw("failed to load persisted webrtc certificate, generating a new one", "error", err)
+		} else if stored != nil && time.Until(stored.Certificate.Expires()) > certificateRotationOverlap {
+			return stored, nil
+		}
+	}
+
+	stored, err := generateCertificate(t.certValidity)
+	if err != nil {
+		return nil, err
+	}
+	if t.certStore != nil {
+		if err := t.certStore.Store(ctx, stored); err != nil {
+			log.Warnw("failed to persist webrtc certificate", "error", err)
+		}
+	}
+	return stored, nil
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of the certificate
+// currently used for new peer connections, so higher layers can pin or
+// verify it. It returns an error if no certificate has been generated yet,
+// which only happens before the first call to NewPeerConnection.
+func (t *transport) CertificateFingerprint() (string, error) {
+	t.certMu.Lock()
+	current := t.currentCert
+	t.certMu.Unlock()
+	if current == nil {
+		return "", errors.New("no webrtc certificate has been generated yet")
+	}
+	return current.Fingerprint()
+}
+
+// iceServers returns the ICE servers to use for a new peer connection,
+// refreshing them from iceServerProvider when the cached credentials are
+// near expiry. A refresh failure falls back to the last known-good
+// credentials rather than failing the dial outright, since stale-but-valid
+// credentials are better than none.
+func (t *transport) iceServers(ctx context.Context) ([]webrtc.ICEServer, error) {
+	t.iceMu.Lock()
+	cached := t.cachedICEServers
+	needsRefresh := cached == nil || (!t.cachedICEExpiry.IsZero() && time.Until(t.cachedICEExpiry) < iceCredentialRefreshMargin)
+	t.iceMu.Unlock()
+	if !needsRefresh {
+		return cached, nil
+	}
+
+	servers, expiry, err := t.iceServerProvider.ICEServers(ctx)
+	if err != nil {
+		if cached != nil {
+			log.Warnw("failed to refresh ICE server credentials, reusing cached ones", "error", err)
+			return cached, nil
+		}
+		return nil, fmt.Errorf("failed to fetch ICE servers: %w", err)
+	}
+
+	t.iceMu.Lock()
+	t.cachedICEServers = servers
+	t.cachedICEExpiry = expiry
+	t.iceMu.Unlock()
+	return servers, nil
+}
+
 // CanDial determines if we can dial to an address
 func (t *transport) CanDial(addr ma.Multiaddr) bool {
 	circuit := false
@@ -207,7 +394,36 @@ func (t *transport) dialWithScope(ctx context.Context, p peer.ID, scope network.
 
 func (t *transport) setupConnection(ctx context.Context, s network.Stream, scope network.ConnManagementScope, raddr ma.Multiaddr) (_ tpt.CapableConn, err error) {
 	r := pbio.NewDelimitedReader(s, maxMsgSize)
-	w := pbio.NewDelimitedWriter(s)
+	var w pbio.Writer = pbio.NewDelimitedWriter(s)
+	var fuzzW *fuzzWriter
+	if t.signalingFuzz != nil {
+		fuzzW = newFuzzWriter(w, *t.signalingFuzz)
+		w = fuzzW
+	}
+	defer func() {
+		if fuzzW != nil {
+			fuzzW.Flush()
+		}
+	}()
+
+	// ctx bounds every goroutine and callback below, not just the final
+	// select: the ICE candidate writer aborts on ctx.Done, and the reader
+	// goroutine's blocking read is unblocked by forcing the stream deadline
+	// as soon as ctx is cancelled, instead of only being checked in between
+	// reads.
+	//
+	// The deadline starts at connectTimeout, but gathering (below) pulls it
+	// in to t.candidateBatchGrace once both sides finish gathering ICE
+	// candidates, since a remaining ICE pair-check failure at that point is
+	// far more likely than a slow-to-arrive late candidate. It never pushes
+	// the deadline back out, and a connectionState update racing the
+	// deadline can still win the final select.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	start := time.Now()
+	deadlineTimer := time.AfterFunc(connectTimeout, cancel)
+	defer deadlineTimer.Stop()
+	g, gCtx := errgroup.WithContext(ctx)
 
 	var pc *webrtc.PeerConnection
 	defer func() {
@@ -215,7 +431,7 @@ func (t *transport) setupConnection(ctx context.Context, s network.Stream, scope
 			pc.Close()
 		}
 	}()
-	pc, err = t.NewPeerConnection()
+	pc, err = t.NewPeerConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create webrtc.PeerConnection: %w", err)
 	}
@@ -235,11 +451,34 @@ func (t *transport) setupConnection(ctx context.Context, s network.Stream, scope
 		}
 	})
 
-	// register local ICE Candidate found callback
+	// register local ICE Candidate found callback. Candidates are batched by
+	// writeBatchedCandidates rather than written one-per-message, and
+	// gathering tracks when both sides are done so the final select below
+	// can shorten its wait once only the ICE pair check is left running.
 	writeErr := make(chan error, 1)
+	candidateCh := make(chan string, 16)
+	localGatheringDone := make(chan struct{}, 1)
+	grace := t.candidateBatchGrace
+	if grace <= 0 {
+		grace = postGatheringGraceTimeout
+	}
+	gathering := newGatheringTracker(func() {
+		if remaining := connectTimeout - time.Since(start); remaining > grace {
+			deadlineTimer.Reset(grace)
+		}
+	})
 	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
-		// The callback can be called with a nil pointer
+		// The callback can be called with a nil pointer to signal that
+		// local candidate gathering has finished.
 		if candidate == nil {
+			gathering.LocalDone()
+			select {
+			case localGatheringDone <- struct{}{}:
+			default:
+			}
+			return
+		}
+		if ctx.Err() != nil {
 			return
 		}
 		b, err := json.Marshal(candidate.ToJSON())
@@ -251,23 +490,25 @@ func (t *transport) setupConnection(ctx context.Context, s network.Stream, scope
 			}
 			return
 		}
-		data := string(b)
-		msg := pb.Message{
-			Type: pb.Message_ICE_CANDIDATE.Enum(),
-			Data: &data,
+		select {
+		case candidateCh <- string(b):
+		case <-ctx.Done():
 		}
-		if err = w.WriteMsg(&msg); err != nil {
-			// We only want to write a single error on this channel
+	})
+
+	// de-register candidate callback
+	defer pc.OnICECandidate(func(_ *webrtc.ICECandidate) {})
+
+	g.Go(func() error {
+		if err := writeBatchedCandidates(gCtx, w, candidateCh, localGatheringDone, t.candidateBatchWindow); err != nil {
 			select {
-			case writeErr <- fmt.Errorf("failed to write candidate: %w", err):
+			case writeErr <- fmt.Errorf("failed to write candidates: %w", err):
 			default:
 			}
 		}
+		return nil
 	})
 
-	// de-register candidate callback
-	defer pc.OnICECandidate(func(_ *webrtc.ICECandidate) {})
-
 	// We initialise a data channel otherwise the offer will have no ICE components
 	// https://stackoverflow.com/a/38872920/759687
 	// We use out-of-band negotiation(negotiated=true), to ensure that this channel doesn't
@@ -317,47 +558,22 @@ func (t *transport) setupConnection(ctx context.Context, s network.Stream, scope
 		return nil, fmt.Errorf("failed to set remote description: %w", err)
 	}
 
-	readErr := make(chan error, 1)
-	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
-	defer cancel()
 	// start a goroutine to read candidates
-	go func() {
-		for {
-			if ctx.Err() != nil {
-				return
-			}
-
-			err := r.ReadMsg(&msg)
-			if err == io.EOF {
-				return
-			}
-			if err != nil {
-				readErr <- fmt.Errorf("read failed: %w", err)
-				return
-			}
-			if msg.Type == nil || *msg.Type != pb.Message_ICE_CANDIDATE {
-				readErr <- fmt.Errorf("invalid message: expected %s got %s", pb.Message_ICE_CANDIDATE, msg.Type)
-				return
-			}
-			// Ignore without erroring on empty message.
-			// Pion has a case where OnCandidate callback may be called with a nil
-			// candidate
-			if msg.Data == nil || *msg.Data == "" {
-				log.Debugf("received empty candidate from %s", s.Conn().RemotePeer())
-				continue
-			}
-
-			var init webrtc.ICECandidateInit
-			if err := json.Unmarshal([]byte(*msg.Data), &init); err != nil {
-				readErr <- fmt.Errorf("failed to unmarshal ice candidate %w", err)
-				return
-			}
-			if err := pc.AddICECandidate(init); err != nil {
-				readErr <- fmt.Errorf("failed to add ice candidate: %w", err)
-				return
-			}
-		}
-	}()
+	g.Go(func() error {
+		return readCandidates(gCtx, r, pc, gathering, func(reason string) {
+			log.Debugf("%s from %s", reason, s.Conn().RemotePeer())
+		})
+	})
+	// ReadMsg blocks on the stream's deadline, not on gCtx, so refresh the
+	// deadline as soon as the context is done to unblock the goroutine above
+	// promptly instead of leaking it until the stream's own timeout fires.
+	g.Go(func() error {
+		<-gCtx.Done()
+		s.SetDeadline(time.Now())
+		return nil
+	})
+	readErr := make(chan error, 1)
+	go func() { readErr <- g.Wait() }()
 
 	select {
 	case <-ctx.Done():
@@ -365,7 +581,17 @@ func (t *transport) setupConnection(ctx context.Context, s network.Stream, scope
 		return nil, ctx.Err()
 	case err := <-readErr:
 		pc.Close()
-		return nil, err
+		if err != nil {
+			return nil, err
+		}
+		// g.Wait() only returns once gCtx is done, since the
+		// deadline-forcing goroutine waits for it. A nil result means ctx
+		// was cancelled after the reader had already stopped at EOF
+		// (the remote closed the stream), without us ever observing a
+		// Connected state; treat that the same as any other failure to
+		// establish the connection instead of falling through as if it
+		// had succeeded.
+		return nil, fmt.Errorf("signaling stream closed before the peer connection reached state %s", webrtc.PeerConnectionStateConnected)
 	case err := <-writeErr:
 		pc.Close()
 		return nil, err
@@ -441,7 +667,30 @@ func (*transport) Proxy() bool {
 	return false
 }
 
-func (t *transport) NewPeerConnection() (*webrtc.PeerConnection, error) {
+// NewPeerConnection builds a new webrtc.PeerConnection using t's ICE
+// servers and certificate. ctx bounds resolving both of those (ICE
+// credential refresh and certificate load/generation), not the returned
+// connection's lifetime.
+//
+// This added a ctx parameter to the prior signature. The only caller in
+// this checkout (setupConnection, below) is updated; handleSignalingStream
+// would be a second caller on the answerer side, but it isn't present in
+// this checkout to update (see the note below the transport struct) — its
+// call to this constructor needs the same update before this lands.
+func (t *transport) NewPeerConnection(ctx context.Context) (*webrtc.PeerConnection, error) {
+	servers, err := t.iceServers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve ICE servers: %w", err)
+	}
+	cert, err := t.certificate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve webrtc certificate: %w", err)
+	}
+	config := webrtc.Configuration{
+		Certificates: []webrtc.Certificate{*cert},
+		ICEServers:   servers,
+	}
+
 	loggerFactory := pionlogger.NewDefaultLoggerFactory()
 	logLevel := pionlogger.LogLevelDisabled
 	switch log.Level() {
@@ -461,7 +710,7 @@ func (t *transport) NewPeerConnection() (*webrtc.PeerConnection, error) {
 	s.SetIncludeLoopbackCandidate(true)
 	s.SetReceiveMTU(receiveMTU)
 	api := webrtc.NewAPI(webrtc.WithSettingEngine(s))
-	return api.NewPeerConnection(t.webrtcConfig)
+	return api.NewPeerConnection(config)
 }
 
 // getRelayAddr removes /webrtc from addr and returns a circuit v2 only address